@@ -0,0 +1,79 @@
+package fixer
+
+import (
+	"go/token"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/tools/go/analysis"
+
+	"github.com/thockin/klog-to-logr/importer"
+)
+
+// AsAnalyzer exposes fix as a golang.org/x/tools/go/analysis.Analyzer, so it
+// can run under singlechecker/multichecker, `go vet -vettool=`, or inside
+// gopls -- getting inline diagnostics for every match and a one-click
+// "apply suggested fix", without ever touching files on disk directly.
+//
+// Each file that the fix would have rewritten gets a single diagnostic
+// carrying one whole-file analysis.SuggestedFix; this mirrors the existing
+// rewrite pipeline, which likewise works on whole files -- reformatting
+// after every applied fix and running the goimports cleanup pass once the
+// file's fixes are done -- rather than computing a minimal diff. The
+// goimports pass matters here as much as it
+// does on the CLI path: a fix like Fatal's os.Exit(255) injection assumes
+// it'll run, and skipping it would offer a "suggested fix" that doesn't
+// compile.
+func AsAnalyzer(fix Fix) *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Name: fix.Name,
+		Doc:  fix.Description,
+		Run: func(pass *analysis.Pass) (interface{}, error) {
+			loader := importer.FromAnalysisPass(pass)
+
+			for _, file := range pass.Files {
+				tf := pass.Fset.File(file.Pos())
+				info := FileInfo{
+					Name: tf.Name(),
+					AST:  file,
+					Fset: pass.Fset,
+					Diag: func(pos token.Pos, msg string) {
+						pass.Reportf(pos, "%s: %s", fix.Name, msg)
+					},
+				}
+
+				if !fix.Execute(info, loader, logr.Discard()) {
+					continue
+				}
+
+				after, err := CleanupImports(info.AST, info.Fset, info.Name)
+				if err != nil {
+					return nil, err
+				}
+
+				// file.Pos()/file.End() span only the "package" keyword
+				// through the last declaration, excluding any leading
+				// license/doc comment and trailing comments. after is full
+				// goimports output -- header and all -- so anchoring the
+				// edit there would duplicate the header and drop trailing
+				// comments. Use the token.File's true byte range instead.
+				start := tf.Pos(0)
+				end := tf.Pos(tf.Size())
+
+				pass.Report(analysis.Diagnostic{
+					Pos:     file.Pos(),
+					Message: fix.Name + ": rewrite available",
+					SuggestedFixes: []analysis.SuggestedFix{{
+						Message: "Apply " + fix.Name,
+						TextEdits: []analysis.TextEdit{{
+							Pos:     start,
+							End:     end,
+							NewText: after,
+						}},
+					}},
+				})
+			}
+
+			return nil, nil
+		},
+	}
+}
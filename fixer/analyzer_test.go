@@ -0,0 +1,72 @@
+package fixer
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/tools/go/analysis"
+
+	"github.com/thockin/klog-to-logr/importer"
+)
+
+// TestAsAnalyzerEditRange covers a file with a leading doc comment: file.Pos()
+// and file.End() only span the "package" keyword through the last
+// declaration, excluding that comment, so anchoring the suggested fix's
+// TextEdit to them would duplicate the header when "after" (full goimports
+// output) is applied. The edit must instead span the token.File's true byte
+// range.
+func TestAsAnalyzerEditRange(t *testing.T) {
+	const src = `// Package p is documented here.
+package p
+
+func f() {}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parsing test source: %v", err)
+	}
+
+	fix := Fix{
+		Name: "testfix",
+		Execute: func(info FileInfo, _ importer.Loader, _ logr.Logger) bool {
+			return true
+		},
+	}
+
+	var diags []analysis.Diagnostic
+	pass := &analysis.Pass{
+		Fset:  fset,
+		Files: []*ast.File{file},
+		Report: func(d analysis.Diagnostic) {
+			diags = append(diags, d)
+		},
+	}
+
+	if _, err := AsAnalyzer(fix).Run(pass); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("got %d diagnostics, want 1", len(diags))
+	}
+	edits := diags[0].SuggestedFixes[0].TextEdits
+	if len(edits) != 1 {
+		t.Fatalf("got %d edits, want 1", len(edits))
+	}
+
+	tf := fset.File(file.Pos())
+	wantStart, wantEnd := tf.Pos(0), tf.Pos(tf.Size())
+	if file.Pos() == wantStart {
+		t.Fatalf("test is ineffective: file.Pos() already equals the file's start, so it wouldn't catch a regression to file.Pos()/file.End()")
+	}
+	if got := edits[0].Pos; got != wantStart {
+		t.Errorf("edit start = %v, want %v (the file's true start, not file.Pos() which excludes the leading doc comment)", got, wantStart)
+	}
+	if got := edits[0].End; got != wantEnd {
+		t.Errorf("edit end = %v, want %v (the file's true end)", got, wantEnd)
+	}
+}
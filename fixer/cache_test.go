@@ -0,0 +1,110 @@
+package fixer
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheGetPutRoundTrip(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "cache"), Mode: CacheReadWrite}
+
+	src := []byte("package p\n")
+	if err := c.Put("pkgID", src, "fixName", "v1", "confighash", []byte("rewritten"), false); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	result, noop, ok := c.Get("pkgID", src, "fixName", "v1", "confighash")
+	if !ok {
+		t.Fatalf("Get: cache miss, want hit")
+	}
+	if noop {
+		t.Errorf("noop = true, want false")
+	}
+	if string(result) != "rewritten" {
+		t.Errorf("result = %q, want %q", result, "rewritten")
+	}
+}
+
+func TestCacheGetPutNoop(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "cache"), Mode: CacheReadWrite}
+
+	src := []byte("package p\n")
+	if err := c.Put("pkgID", src, "fixName", "v1", "confighash", nil, true); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	result, noop, ok := c.Get("pkgID", src, "fixName", "v1", "confighash")
+	if !ok {
+		t.Fatalf("Get: cache miss, want hit")
+	}
+	if !noop {
+		t.Errorf("noop = false, want true")
+	}
+	if len(result) != 0 {
+		t.Errorf("result = %q, want empty", result)
+	}
+}
+
+func TestCacheGetMissOnDifferentKeyParts(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "cache"), Mode: CacheReadWrite}
+
+	src := []byte("package p\n")
+	if err := c.Put("pkgID", src, "fixName", "v1", "confighash", []byte("rewritten"), false); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	cases := []struct {
+		name                                   string
+		pkgID, fixName, fixVersion, configHash string
+		src                                    []byte
+	}{
+		{"different pkgID", "otherPkg", "fixName", "v1", "confighash", src},
+		{"different fixName", "pkgID", "otherFix", "v1", "confighash", src},
+		{"different fixVersion", "pkgID", "fixName", "v2", "confighash", src},
+		{"different configHash", "pkgID", "fixName", "v1", "otherhash", src},
+		{"different src", "pkgID", "fixName", "v1", "confighash", []byte("package p\n\nvar x int\n")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, ok := c.Get(tc.pkgID, tc.src, tc.fixName, tc.fixVersion, tc.configHash); ok {
+				t.Errorf("Get: cache hit, want miss")
+			}
+		})
+	}
+}
+
+func TestCacheReadOnlyDoesNotWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c := &Cache{Dir: dir, Mode: CacheReadOnly}
+
+	src := []byte("package p\n")
+	if err := c.Put("pkgID", src, "fixName", "v1", "confighash", []byte("rewritten"), false); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, _, ok := c.Get("pkgID", src, "fixName", "v1", "confighash"); ok {
+		t.Errorf("Get: cache hit under CacheReadOnly after a Put, want miss")
+	}
+}
+
+func TestCacheOffDoesNotWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c := &Cache{Dir: dir, Mode: CacheOff}
+
+	src := []byte("package p\n")
+	if err := c.Put("pkgID", src, "fixName", "v1", "confighash", []byte("rewritten"), false); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, _, ok := c.Get("pkgID", src, "fixName", "v1", "confighash"); ok {
+		t.Errorf("Get: cache hit under CacheOff, want miss")
+	}
+}
+
+func TestCacheNilIsNoop(t *testing.T) {
+	var c *Cache
+	if err := c.Put("pkgID", []byte("src"), "fixName", "v1", "confighash", []byte("rewritten"), false); err != nil {
+		t.Fatalf("Put on nil *Cache: %v", err)
+	}
+	if _, _, ok := c.Get("pkgID", []byte("src"), "fixName", "v1", "confighash"); ok {
+		t.Errorf("Get on nil *Cache: hit, want miss")
+	}
+}
@@ -3,9 +3,13 @@ package fixer
 import (
 	"go/ast"
 	"go/parser"
+	"go/token"
+	"go/types"
 
 	"github.com/go-logr/logr"
 	"golang.org/x/tools/go/packages"
+
+	"github.com/thockin/klog-to-logr/importer"
 )
 
 // FileInfo represents the information needed to process some file
@@ -13,14 +17,41 @@ import (
 type FileInfo struct {
 	Name string
 	Package *packages.Package
+	Fset *token.FileSet
 	AST *ast.File
+
+	// Diag, if non-nil, lets a Fix surface a diagnostic at a position in
+	// this file beyond a plain rewrite -- e.g. logrFix.findErrorArg
+	// flagging an argument it had to guess at rather than silently
+	// picking one. The CLI path wires this to append to Package.Errors;
+	// the go/analysis path wires it to pass.Reportf.
+	Diag func(pos token.Pos, msg string)
 }
 
 // Fix represents some fix to be applied to a given file.
 type Fix struct {
 	Name string
-	Execute func(FileInfo, logr.Logger) bool
+	Execute func(FileInfo, importer.Loader, logr.Logger) bool
 	Description string
+
+	// Version identifies the behavior of Execute for cache-invalidation
+	// purposes: bump it whenever a change to the fix could produce a
+	// different result for the same input, so stale cache entries aren't
+	// served across upgrades.
+	Version string
+	// ConfigHash is a digest of whatever configuration (target package,
+	// symbol overrides, etc) Execute closes over, folded into the cache key
+	// alongside Name and Version.
+	ConfigHash string
+	// NeedsRetypecheck tells the Fixer that this fix's edits may invalidate
+	// type information that it itself (for a cache hit) or a subsequent fix
+	// in the same pipeline depends on, so the file must be re-parsed and
+	// the package re-typechecked before that type info is read again.
+	// Fixes that only touch syntax unrelated to typed lookups can leave
+	// this false: the next fix then works on the same in-memory AST with
+	// no reparse in between. Each fix still makes its own traversal of
+	// the file; fixes are not merged into a single astutil.Apply pass.
+	NeedsRetypecheck bool
 }
 
 // Fixer executes fixes against packages
@@ -28,6 +59,23 @@ type Fixer struct {
 	Log logr.Logger
 	Fixes []Fix
 
+	// Cache, if non-nil, short-circuits re-running a fix against a file
+	// whose content and fix identity haven't changed since the last run.
+	Cache *Cache
+
+	// ExportCache, if non-nil, lets the Loader handed to each fix skip
+	// re-deserializing a dependency package's type information across runs.
+	ExportCache *importer.ExportCache
+
+	// ExtraImports supplies the type information for import paths that a fix
+	// can introduce into a file without them ever having been part of the
+	// package graph the caller's packages.Load walked -- e.g. the
+	// logr.Logger-shaped target package every fixes.LogrFix adds an import
+	// of. Retypechecking after a NeedsRetypecheck fix needs to resolve these
+	// the same way it resolves pkg.Imports; a fix that adds an import not
+	// covered by pkg.Imports or ExtraImports will fail retypechecking.
+	ExtraImports map[string]*types.Package
+
 	HandleFix func(FileInfo) error
 }
 
@@ -35,46 +83,121 @@ func (f *Fixer) FixPackage(pkg *packages.Package) error {
 	pkgLog := f.Log.WithValues("package", pkg.PkgPath)
 	pkgLog.V(1).Info("applying fixes", "file count", len(pkg.Syntax))
 
+	loader := importer.FromPackageWithCache(pkg, f.ExportCache)
+
 	for i, ast := range pkg.Syntax {
-		filename := pkg.GoFiles[i]
+		// pkg.Syntax is parsed from CompiledGoFiles, not GoFiles -- the two
+		// lists differ (in length and order) for cgo packages, where
+		// GoFiles omits the cgo-preprocessed files and GoFiles/Syntax would
+		// pair an AST with the wrong filename.
+		filename := pkg.CompiledGoFiles[i]
 		fileLog := pkgLog.WithValues("file", filename)
 		info := FileInfo{
 			Name: filename,
 			AST: ast,
 			Package: pkg,
+			Fset: pkg.Fset,
+			Diag: func(pos token.Pos, msg string) {
+				AddErrorFrom(msg, pos, pkg)
+			},
 		}
-		if err := f.fixFile(info, fileLog); err != nil {
+		finalAST, err := f.fixFile(i, info, loader, fileLog)
+		if err != nil {
 			return err
 		}
+		// Keep pkg.Syntax in step with what's actually on (or headed to)
+		// disk: a later file in this loop, or a re-typecheck triggered by
+		// this one, reads the package's files back out of pkg.Syntax.
+		pkg.Syntax[i] = finalAST
 	}
 
 	return nil
 }
 
-func (f *Fixer) fixFile(info FileInfo, log logr.Logger) error {
+func (f *Fixer) fixFile(fileIndex int, info FileInfo, loader importer.Loader, log logr.Logger) (*ast.File, error) {
 	// Apply fixes to this file.
 	fixed := false
 	for _, fix := range f.Fixes {
-		if fix.Execute(info, log.WithValues("fix", fix.Name)) {
+		fixLog := log.WithValues("fix", fix.Name)
+
+		src, err := GofmtFile(info.AST, info.Fset)
+		if err != nil {
+			return nil, err
+		}
+
+		if cached, noop, ok := f.Cache.Get(info.Package.ID, src, fix.Name, fix.Version, fix.ConfigHash); ok {
+			fixLog.V(1).Info("cache hit", "noop", noop)
+			if noop {
+				continue
+			}
 			fixed = true
+			if info.AST, err = parser.ParseFile(info.Fset, info.Name, cached, parser.ParseComments); err != nil {
+				return nil, err
+			}
+			if fix.NeedsRetypecheck {
+				if err := f.retypecheck(fileIndex, info); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		changed := fix.Execute(info, loader, fixLog)
+
+		newSrc, err := GofmtFile(info.AST, info.Fset)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.Cache.Put(info.Package.ID, src, fix.Name, fix.Version, fix.ConfigHash, newSrc, !changed); err != nil {
+			fixLog.Error(err, "failed to write cache entry")
+		}
+		if !changed {
+			continue
+		}
+		fixed = true
 
-			// The AST changed, so we must re-parse it for the next fix to be
-			// additive.  We don't need to track the resultant ast.File beyond
-			// this function because the whole universe will be torn down in the
-			// outer loop calling this (for each top-level arg).
-			newSrc, err := GofmtFile(info.AST, info.Package.Fset)
-			if err != nil {
-				return err
+		// Only re-parse (and, via the fresh AST, expose updated type info
+		// to the next fix) when the fix says it's needed.  After a fix that
+		// doesn't set NeedsRetypecheck, the next fix runs against the same
+		// AST instead of paying for a reparse.
+		if fix.NeedsRetypecheck {
+			if info.AST, err = parser.ParseFile(info.Fset, info.Name, newSrc, parser.ParseComments); err != nil {
+				return nil, err
 			}
-			info.AST, err = parser.ParseFile(info.Package.Fset, info.Name, newSrc, parser.ParseComments)
-			if err != nil {
-				return err
+			// The reparse just gave this file brand new AST node
+			// identities, so the *types.Info a Loader hands back to the
+			// next fix -- keyed on the old identities -- would otherwise
+			// silently stop matching anything in this file. Re-typecheck
+			// the whole package against the updated pkg.Syntax so it's
+			// current again before the next fix runs.
+			if err := f.retypecheck(fileIndex, info); err != nil {
+				return nil, err
 			}
 		}
 	}
 	if !fixed {
-		return nil
+		return info.AST, nil
 	}
 
-	return f.HandleFix(info)
+	cleaned, err := CleanupImports(info.AST, info.Fset, info.Name)
+	if err != nil {
+		return nil, err
+	}
+	if info.AST, err = parser.ParseFile(info.Fset, info.Name, cleaned, parser.ParseComments); err != nil {
+		return nil, err
+	}
+
+	if err := f.HandleFix(info); err != nil {
+		return nil, err
+	}
+	return info.AST, nil
+}
+
+// retypecheck re-typechecks info.Package after info.AST (at fileIndex in
+// info.Package.Syntax) changed out from under its existing type info,
+// updating info.Package.Types and info.Package.TypesInfo in place so a
+// Loader backed by them is current again.
+func (f *Fixer) retypecheck(fileIndex int, info FileInfo) error {
+	info.Package.Syntax[fileIndex] = info.AST
+	return retypecheckPackage(info.Package, f.ExtraImports)
 }
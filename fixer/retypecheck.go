@@ -0,0 +1,67 @@
+package fixer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// retypecheckPackage re-runs the type checker over pkg.Syntax and replaces
+// pkg.Types/pkg.TypesInfo with the result, in place. It's used after a fix
+// with NeedsRetypecheck set has reparsed one of pkg.Syntax's files: the old
+// pkg.TypesInfo is keyed to that file's previous AST node identities, so
+// anything reading it through a Loader would silently miss for the new AST.
+//
+// Dependency packages are never reparsed here, so their already-typechecked
+// pkg.Imports[path].Types can just be reused rather than re-running the
+// checker for the whole transitive graph on every retypecheck. extra covers
+// import paths a fix may have just added that weren't part of pkg.Imports to
+// begin with (see Fixer.ExtraImports); it may be nil.
+func retypecheckPackage(pkg *packages.Package, extra map[string]*types.Package) error {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+
+	cfg := &types.Config{
+		Importer: &depImporter{pkg: pkg, extra: extra},
+	}
+
+	typesPkg, err := cfg.Check(pkg.PkgPath, pkg.Fset, pkg.Syntax, info)
+	if err != nil {
+		return err
+	}
+
+	pkg.Types = typesPkg
+	pkg.TypesInfo = info
+	return nil
+}
+
+// depImporter resolves imports for retypecheckPackage from pkg.Imports,
+// which packages.Load already populated (via NeedDeps/NeedImports) with
+// every dependency's own *types.Package, falling back to extra for an import
+// path a fix added that pkg.Imports never had -- so retypechecking never
+// needs to invoke the go command or touch disk.
+type depImporter struct {
+	pkg   *packages.Package
+	extra map[string]*types.Package
+}
+
+func (d *depImporter) Import(path string) (*types.Package, error) {
+	if path == d.pkg.PkgPath {
+		return d.pkg.Types, nil
+	}
+	if dep, ok := d.pkg.Imports[path]; ok {
+		return dep.Types, nil
+	}
+	if tpkg, ok := d.extra[path]; ok {
+		return tpkg, nil
+	}
+	return nil, fmt.Errorf("retypecheck: unknown import %q for package %q", path, d.pkg.PkgPath)
+}
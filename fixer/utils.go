@@ -5,6 +5,8 @@ import (
 	"go/format"
 	"go/token"
 	"bytes"
+
+	"golang.org/x/tools/imports"
 )
 
 // gofmtFile formats the given file that's part of the given fileset,
@@ -16,3 +18,20 @@ func GofmtFile(f *ast.File, fset *token.FileSet) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// CleanupImports gofmt's f, then runs the result through a goimports-style
+// pass: pruning imports that ended up unused (e.g. "fmt" if no format
+// helper survived a fix) and adding ones a fix assumed would be present
+// (e.g. "os" for the os.Exit(255) that tryPkgStmtCall injects), rather than
+// requiring each fix to keep the import block consistent itself. Every
+// entry point that hands a fix's rewritten AST back to its caller --
+// whether writing it to disk or building an analysis.SuggestedFix -- must
+// run this first, or a fix that assumed an import would be synthesized
+// later produces code that doesn't compile.
+func CleanupImports(f *ast.File, fset *token.FileSet, filename string) ([]byte, error) {
+	src, err := GofmtFile(f, fset)
+	if err != nil {
+		return nil, err
+	}
+	return imports.Process(filename, src, nil)
+}
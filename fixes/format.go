@@ -0,0 +1,90 @@
+package fixes
+
+import (
+	"regexp"
+	"strings"
+)
+
+// formatFields is the result of decomposing a klog/glog-style Printf format
+// string into a plain message and the keys implied by any "key=%v" or
+// "key: %s" fragments that precede a verb.
+type formatFields struct {
+	// Message is the format string with every recognized key fragment and
+	// verb stripped out, up to the first verb that has no value left to
+	// bind; that verb and everything after it are kept as written.
+	Message string
+	// Keys holds one entry per bound verb (at most numArgs of them), in
+	// order; entries for verbs whose key couldn't be determined are "".
+	Keys []string
+}
+
+var (
+	// verbRE matches a literal "%%" ahead of a real verb, so the former
+	// doesn't get mistaken for the latter: %[-+# 0-9.]*[a-zA-Z] on its own
+	// would match the second "%" in "%%" plus whatever letter followed it
+	// in the rest of the string.
+	verbRE      = regexp.MustCompile(`%%|%[-+# 0-9.]*[a-zA-Z]`)
+	keyPrefixRE = regexp.MustCompile(`(\pL[\pL\p{Nd}_]*)(=|:\s*)$`)
+)
+
+// parseFormatString splits format (already unquoted) into a message prefix
+// and the keys bound to each verb, e.g. "reconciling pod=%s in ns: %s"
+// becomes message "reconciling in" with keys ["pod", "ns"], while
+// "reconciling pod %s in ns %s" becomes message "reconciling pod in ns" with
+// keys ["", ""].
+//
+// numArgs caps how many verbs actually have a value left to bind: the
+// caller may have already peeled a value off of callexpr.Args (e.g. fixError
+// extracting the error argument) before the rest reach here, so a trailing
+// verb in the format string can outnumber the values left to satisfy it.
+// Verbs beyond numArgs are left untouched -- key prefix, verb and all -- in
+// the message rather than being stripped with nothing to show for it; this
+// is what keeps the common "failed: %v" error-wrap idiom intact when the
+// "%v" belongs to the error that fixError already pulled out.
+//
+// Only the common "<word>=%v" and "<word>: %s" shapes are recognized; verbs
+// that aren't preceded by one of those get an empty key, leaving the caller
+// to fall back to its own inference. A literal "%%" contributes no key (it
+// doesn't consume a call argument) and is preserved as a single "%" in the
+// message, unless it comes after a verb left untouched for lack of a value,
+// in which case it stays "%%" along with the rest of that tail.
+func parseFormatString(format string, numArgs int) formatFields {
+	locs := verbRE.FindAllStringIndex(format, -1)
+	if len(locs) == 0 {
+		return formatFields{Message: strings.TrimSpace(format)}
+	}
+
+	var b strings.Builder
+	var keys []string
+	consumed := 0
+	last := 0
+	for _, loc := range locs {
+		prefix := format[last:loc[0]]
+		if format[loc[0]:loc[1]] == "%%" {
+			b.WriteString(prefix)
+			b.WriteString("%")
+			last = loc[1]
+			continue
+		}
+		if consumed >= numArgs {
+			// No value remains to bind this verb to -- leave it, and
+			// whatever precedes it, exactly as written.
+			break
+		}
+		key := ""
+		if m := keyPrefixRE.FindStringSubmatch(prefix); m != nil {
+			key = m[1]
+			prefix = prefix[:len(prefix)-len(m[0])]
+		}
+		keys = append(keys, key)
+		b.WriteString(prefix)
+		last = loc[1]
+		consumed++
+	}
+	b.WriteString(format[last:])
+
+	return formatFields{
+		Message: strings.Join(strings.Fields(b.String()), " "),
+		Keys:    keys,
+	}
+}
@@ -0,0 +1,71 @@
+package fixes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFormatString(t *testing.T) {
+	cases := []struct {
+		name    string
+		format  string
+		numArgs int
+		want    formatFields
+	}{
+		{
+			name:   "no verbs",
+			format: "reconciling",
+			want:   formatFields{Message: "reconciling"},
+		},
+		{
+			name:    "key=%v fragment",
+			format:  "reconciling pod=%s",
+			numArgs: 1,
+			want:    formatFields{Message: "reconciling", Keys: []string{"pod"}},
+		},
+		{
+			name:    "key: %s fragment",
+			format:  "reconciling pod: %s in ns: %s",
+			numArgs: 2,
+			want:    formatFields{Message: "reconciling in", Keys: []string{"pod", "ns"}},
+		},
+		{
+			name:    "verb with no recognizable key prefix",
+			format:  "reconciling %s",
+			numArgs: 1,
+			want:    formatFields{Message: "reconciling", Keys: []string{""}},
+		},
+		{
+			name:    "literal %% is not mistaken for a verb",
+			format:  "cpu=%d%% done, pod=%s",
+			numArgs: 2,
+			want:    formatFields{Message: "% done,", Keys: []string{"cpu", "pod"}},
+		},
+		{
+			name:   "bare %% with no other verbs",
+			format: "100%% done",
+			want:   formatFields{Message: "100% done"},
+		},
+		{
+			name:    "trailing verb with no value left to bind keeps its text",
+			format:  "failed: %v",
+			numArgs: 0,
+			want:    formatFields{Message: "failed: %v"},
+		},
+		{
+			name:    "only the verbs with values left get stripped as keys",
+			format:  "count=%d failed: %v",
+			numArgs: 1,
+			want:    formatFields{Message: "failed: %v", Keys: []string{"count"}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseFormatString(tc.format, tc.numArgs)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseFormatString(%q, %d) = %+v, want %+v", tc.format, tc.numArgs, got, tc.want)
+			}
+		})
+	}
+}
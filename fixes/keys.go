@@ -0,0 +1,58 @@
+package fixes
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// inferKey derives a structured-log key name for arg from its shape and, if
+// available, its static type.  It returns "" if no useful key could be
+// derived, in which case the caller should fall back to a positional key.
+func inferKey(arg ast.Expr, typeInfo *types.Info) string {
+	switch e := arg.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		// pod.Name -> "name"
+		return lowerFirst(e.Sel.Name)
+	case *ast.CallExpr:
+		// obj.GetNamespace() -> "namespace", foo.Name() -> "name"
+		if sel, ok := e.Fun.(*ast.SelectorExpr); ok {
+			return lowerFirst(strings.TrimPrefix(sel.Sel.Name, "Get"))
+		}
+	case *ast.CompositeLit:
+		if typeInfo == nil {
+			return ""
+		}
+		if t := typeInfo.Types[e].Type; t != nil {
+			return lowerFirst(shortTypeName(t.String()))
+		}
+	case *ast.IndexExpr:
+		// pods[i] -> "pods"
+		if id, ok := e.X.(*ast.Ident); ok {
+			return id.Name
+		}
+		return inferKey(e.X, typeInfo)
+	}
+	return ""
+}
+
+// lowerFirst lower-cases the first rune of s, leaving the rest untouched.
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// shortTypeName strips any package qualifier and pointer/slice prefixes
+// from a types.Type.String() result, e.g. "*k8s.io/api/core/v1.Pod" ->
+// "Pod".
+func shortTypeName(typeStr string) string {
+	typeStr = strings.TrimLeft(typeStr, "*[]")
+	if dot := strings.LastIndexByte(typeStr, '.'); dot >= 0 {
+		typeStr = typeStr[dot+1:]
+	}
+	return typeStr
+}
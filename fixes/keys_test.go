@@ -0,0 +1,118 @@
+package fixes
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// exprOfType parses a standalone expression (with the given surrounding
+// declarations in scope, if any) and returns it along with the type info
+// the type checker produced for it.
+func exprOfType(t *testing.T, decls, exprSrc string) (ast.Expr, *types.Info) {
+	t.Helper()
+	src := "package p\n" + decls + "\nvar _ = " + exprSrc + "\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, 0)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", src, err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+	}
+	conf := types.Config{}
+	if _, err := conf.Check("p", fset, []*ast.File{file}, info); err != nil {
+		t.Fatalf("typechecking %q: %v", src, err)
+	}
+	return file.Decls[len(file.Decls)-1].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0], info
+}
+
+func TestInferKey(t *testing.T) {
+	cases := []struct {
+		name  string
+		decls string
+		expr  string
+		want  string
+	}{
+		{
+			name:  "identifier",
+			decls: `var pod int`,
+			expr:  "pod",
+			want:  "pod",
+		},
+		{
+			name:  "selector expression",
+			decls: "type T struct{ Name string }\nvar pod T",
+			expr:  "pod.Name",
+			want:  "name",
+		},
+		{
+			name:  "getter call",
+			decls: "type T struct{}\nfunc (T) GetNamespace() string { return \"\" }\nvar obj T",
+			expr:  "obj.GetNamespace()",
+			want:  "namespace",
+		},
+		{
+			name:  "call without a Get prefix",
+			decls: "type T struct{}\nfunc (T) Name() string { return \"\" }\nvar obj T",
+			expr:  "obj.Name()",
+			want:  "name",
+		},
+		{
+			name:  "composite literal",
+			decls: "type Pod struct{}",
+			expr:  "Pod{}",
+			want:  "pod",
+		},
+		{
+			name:  "index expression on a simple identifier",
+			decls: "var pods []int",
+			expr:  "pods[0]",
+			want:  "pods",
+		},
+		{
+			name:  "index expression on a selector falls back to inferKey on its operand",
+			decls: "type T struct{ Items []int }\nvar list T",
+			expr:  "list.Items[0]",
+			want:  "items",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, info := exprOfType(t, tc.decls, tc.expr)
+			if got := inferKey(expr, info); got != tc.want {
+				t.Errorf("inferKey(%q) = %q, want %q", tc.expr, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLowerFirst(t *testing.T) {
+	cases := map[string]string{
+		"":     "",
+		"Name": "name",
+		"name": "name",
+		"N":    "n",
+	}
+	for in, want := range cases {
+		if got := lowerFirst(in); got != want {
+			t.Errorf("lowerFirst(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestShortTypeName(t *testing.T) {
+	cases := map[string]string{
+		"Pod":                      "Pod",
+		"*k8s.io/api/core/v1.Pod":  "Pod",
+		"[]k8s.io/api/core/v1.Pod": "Pod",
+	}
+	for in, want := range cases {
+		if got := shortTypeName(in); got != want {
+			t.Errorf("shortTypeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -5,13 +5,12 @@
 package fixes
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"go/ast"
-	"go/build"
 	"go/token"
 	"go/types"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -21,7 +20,55 @@ import (
 	"github.com/thockin/klog-to-logr/importer"
 )
 
+// logrFixVersion is bumped whenever a change to logrFix's rewrite logic
+// could change its output for the same input, invalidating any cached
+// results keyed on the old version.
+const logrFixVersion = "4"
+
 const StandardKlogPkg = "k8s.io/klog"
+const StandardKlogV2Pkg = "k8s.io/klog/v2"
+const StandardGlogPkg = "github.com/golang/glog"
+
+// DefaultTarget is the logr-shaped package kfix rewrites calls to when no
+// other TargetConfig is given.
+var DefaultTarget = TargetConfig{
+	ImportPath:   "k8s.io/client-go/log",
+	PackageIdent: "log",
+}
+
+// TargetConfig describes the logr.Logger-shaped package that klog/glog calls
+// should be rewritten to use.
+type TargetConfig struct {
+	// ImportPath is the import path of the target package, e.g.
+	// "github.com/go-logr/glogr" or "sigs.k8s.io/controller-runtime/pkg/log".
+	ImportPath string
+	// PackageIdent is the identifier used for the target package at call
+	// sites (the rewritten selector's lhs), e.g. "log".
+	PackageIdent string
+	// Symbols overrides the target method name used for a given source
+	// method.  Recognized keys are "Info", "Error", "V" and "InitFlags".
+	// A key that's absent (or a nil map) falls back to the source name.
+	Symbols map[string]string
+	// WarningVLevel, if set, rewrites klog/glog "Warning" calls to
+	// `<PackageIdent>.V(*WarningVLevel).Info(...)` instead of a bare Info
+	// call, since logr has no dedicated Warning method.
+	WarningVLevel *int
+}
+
+// symbol returns the target method name for the given source method name,
+// honoring any override in Symbols.
+func (t TargetConfig) symbol(name string) string {
+	if s, ok := t.Symbols[name]; ok {
+		return s
+	}
+	return name
+}
+
+// universeErrorType is the predeclared `error` type itself, used to prefer
+// an argument whose static type is exactly `error` over one that merely
+// implements it (e.g. a named error type) when more than one candidate
+// argument qualifies in findErrorArg.
+var universeErrorType = types.Universe.Lookup("error").Type()
 
 var (
 	errIdent = ast.NewIdent("err")
@@ -65,10 +112,13 @@ func loadErrorType() (*types.Interface, error) {
 	return errorObj.Type().Underlying().(*types.Interface), nil
 }
 
-// LogrFix returns a fixer.Fix that converts calls to klog to logr structured logging.
-func LogrFix(klogPkg string) (fixer.Fix, error) {
+// LogrFix returns a fixer.Fix that converts calls to sourcePkg (a klog or
+// glog import path) into calls against the logr.Logger-shaped target
+// described by config.
+func LogrFix(sourcePkg string, target TargetConfig) (fixer.Fix, error) {
 	res := &logrFixMaker{
-		klogPkg: klogPkg,
+		sourcePkg: sourcePkg,
+		target: target,
 	}
 
 	var err error
@@ -78,16 +128,46 @@ func LogrFix(klogPkg string) (fixer.Fix, error) {
 	}
 
 	return fixer.Fix{
-		Name: "logr",
+		Name: "logr:" + sourcePkg,
 		Execute: res.fix,
-		Description: `Converts klog calls to logr calls`,
+		Description: fmt.Sprintf("Converts %s calls to logr calls against %s", sourcePkg, target.ImportPath),
+		Version: logrFixVersion,
+		ConfigHash: configHash(sourcePkg, target),
+		// This can't safely be false as long as Fixer's cache exists: a
+		// cache hit always re-parses the cached, already-fixed source into
+		// info.AST (fixer.go's cache-hit branch does this unconditionally,
+		// not gated on NeedsRetypecheck), which hands every node in the file
+		// a brand new identity no matter whether *this* run actually
+		// mutated the AST in place or served a hit. The fix inspects
+		// f.loader.TypeInfo() for nearly every call it touches, so without
+		// NeedsRetypecheck here, any fix -- this one on a later cache hit,
+		// or a sibling fix on the same file right after -- would look up
+		// those new nodes in stale TypesInfo keyed on the old ones and
+		// silently fall back to "FIXME"/ambiguous placeholders. So this
+		// can't be relaxed without also changing the cache-hit path to only
+		// reparse when some fix in the pipeline actually needs it.
+		NeedsRetypecheck: true,
 	}, nil
 }
 
+// configHash digests the knobs that influence logrFix's output, so the
+// rewrite cache can tell two runs with different targets apart even when
+// the source file is byte-identical.
+func configHash(sourcePkg string, target TargetConfig) string {
+	level := "none"
+	if target.WarningVLevel != nil {
+		level = strconv.Itoa(*target.WarningVLevel)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%v|%s", sourcePkg, target.ImportPath, target.PackageIdent, target.Symbols, level)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // logrFixMaker produces individual instaces of logrFixes.  It carries common
-// configuration (like the klog package).
+// configuration (like the source and target packages).
 type logrFixMaker struct {
-	klogPkg string
+	sourcePkg string
+	target TargetConfig
 	errorInterface *types.Interface
 }
 
@@ -109,33 +189,49 @@ type logrFix struct {
 	loader importer.Loader
 	info fixer.FileInfo
 
+	// inferredKeys and fixmeKeys count, respectively, the structured-log
+	// keys this fix could infer a name for and the ones it had to fall
+	// back to a positional "argN" name for, across the whole file.
+	inferredKeys int
+	fixmeKeys int
+
 	*logrFixMaker
 }
 
-// fix traverses the AST, looking for calls to klog and replacing them with logr.
+// fix traverses the AST, looking for calls to the source package and
+// replacing them with calls against f.target.
 func (f *logrFix) fix() bool {
-	// If this file doesn't import klog, skip it.
-	impSpec := getImportSpec(f.info.AST, f.klogPkg)
+	// If this file doesn't import the source package, skip it.
+	impSpec := getImportSpec(f.info.AST, f.sourcePkg)
 	if impSpec == nil {
 		return false
 	}
 
-	// Find the canonical import info for the package.
-	// TODO(directxman12): don't repeat this over and over
-	bldpkg, err := build.Import(f.klogPkg, filepath.Dir(f.info.Name), 0)
-	if err != nil {
-		f.log.Error(err, "import failed", "pkg", f.klogPkg)
+	// Find the canonical name of the source package via the loader, which
+	// is backed by packages.Load and so already understands modules, build
+	// tags and cgo -- unlike go/build, which knows none of that and fails
+	// outside a GOPATH layout.
+	srcInfo := f.loader.PackageInfoFor(f.sourcePkg)
+	if srcInfo == nil || srcInfo.ParseInfo == nil {
+		f.log.Error(nil, "could not resolve package info", "pkg", f.sourcePkg)
 		return false
 	}
-	pkgImport := bldpkg.ImportPath
+	pkgImport := f.sourcePkg
 
 	// Get the name of the package.
-	pkgName := bldpkg.Name   // Self-defined
-	if impSpec.Name != nil { // Aliased on import
+	pkgName := srcInfo.ParseInfo.Name() // Self-defined
+	if impSpec.Name != nil {            // Aliased on import
 		pkgName = impSpec.Name.Name
 	}
-	// Rewrite the import in the AST.
-	impSpec.Path = &ast.BasicLit{Kind: token.STRING, Value: `"k8s.io/client-go/log"`}
+
+	// Swap the import via astutil rather than mutating the ImportSpec node
+	// in place: that handles the source package already being aliased and
+	// the target already being imported (AddNamedImport is then a no-op).
+	// The source import is deleted unconditionally here; the final
+	// goimports pass (run by the Fixer once all fixes on the file are done)
+	// prunes any other import left unused and adds ones a rewrite needs.
+	astutil.DeleteImport(f.info.Fset, f.info.AST, f.sourcePkg)
+	astutil.AddNamedImport(f.info.Fset, f.info.AST, f.target.PackageIdent, f.target.ImportPath)
 
 	// Process the AST and fix up calls and references.
 	astutil.Apply(f.info.AST, nil, func(cursor *astutil.Cursor) bool {
@@ -154,6 +250,8 @@ func (f *logrFix) fix() bool {
 		return true
 	})
 
+	f.log.V(2).Info("inferred structured-log keys", "inferred", f.inferredKeys, "fixmes", f.fixmeKeys)
+
 	return true
 }
 
@@ -220,13 +318,13 @@ func (f *logrFix) tryPkgStmtCall(pkgName string, cursor *astutil.Cursor) bool {
 			},
 		})
 	case "InitFlags":
-		fixInitFlags(selexpr)
+		f.fixInitFlags(selexpr)
 	default:
 		return false
 	}
 
 	// Rewrite the package name.
-	selexpr.X = newIdent("log", selexpr.X.Pos())
+	selexpr.X = newIdent(f.target.PackageIdent, selexpr.X.Pos())
 
 	return true
 }
@@ -258,18 +356,31 @@ func (f *logrFix) tryPkgExprCall(pkgName string, cursor *astutil.Cursor) bool {
 
 	// All of these could be embedded in larger expressions.
 	switch selexpr.Sel.Name {
-	case "Info", "Infof", "Infoln", "Warning", "Warningf", "Warningln":
-		fixInfo(selexpr, callexpr)
+	case "Info", "Infof", "Infoln":
+		f.fixInfo(selexpr, callexpr)
+	case "Warning", "Warningf", "Warningln":
+		f.fixInfo(selexpr, callexpr)
+		if lvl := f.target.WarningVLevel; lvl != nil {
+			// logr has no Warning method -- fold it into a V(lvl).Info call.
+			selexpr.X = &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   newIdent(f.target.PackageIdent, selexpr.X.Pos()),
+					Sel: newIdent(f.target.symbol("V"), 0),
+				},
+				Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(*lvl)}},
+			}
+			return true
+		}
 	case "Error", "Errorf", "Errorln":
 		f.fixError(selexpr, callexpr)
 	case "V":
-		// Nothing to do here, just the package name below.
+		selexpr.Sel = newIdent(f.target.symbol("V"), selexpr.Sel.Pos())
 	default:
 		return false
 	}
 
 	// Rewrite the package name.
-	selexpr.X = newIdent("log", selexpr.X.Pos())
+	selexpr.X = newIdent(f.target.PackageIdent, selexpr.X.Pos())
 
 	return true
 }
@@ -306,7 +417,7 @@ func (f *logrFix) tryPkgSymbol(pkgName string, cursor *astutil.Cursor) bool {
 	}
 
 	// Rewrite the package name.
-	selexpr.X = newIdent("log", selexpr.X.Pos())
+	selexpr.X = newIdent(f.target.PackageIdent, selexpr.X.Pos())
 
 	return true
 }
@@ -349,7 +460,7 @@ func (f *logrFix) tryTypedCall(pkgImport string, cursor *astutil.Cursor) bool {
 	case "Verbose":
 		switch selexpr.Sel.Name {
 		case "Info", "Infof", "Infoln":
-			fixInfo(selexpr, callexpr)
+			f.fixInfo(selexpr, callexpr)
 		default:
 			f.log.Error(nil, "unhandled method on Verbose", "method", selexpr.Sel.Name)
 			return false
@@ -369,47 +480,15 @@ func newIdent(name string, pos token.Pos) *ast.Ident {
 	return id
 }
 
-func fixInfo(selexpr *ast.SelectorExpr, callexpr *ast.CallExpr) {
-	selexpr.Sel = newIdent("Info", selexpr.Sel.Pos())
-
-	newArgs := []ast.Expr{getFormatString(callexpr.Args)}
-	// Generate the key-value args.
-	for i, arg := range callexpr.Args {
-		if i == 0 {
-			continue
-		}
-		key := `"FIXME__unknown_key"`
-		if ident, ok := arg.(*ast.Ident); ok {
-			key = `"` + ident.Name + `"`
-		}
-		newArgs = append(newArgs, &ast.BasicLit{Kind: token.STRING, Value: key}, arg)
-	}
-	callexpr.Args = newArgs
+func (f *logrFix) fixInfo(selexpr *ast.SelectorExpr, callexpr *ast.CallExpr) {
+	selexpr.Sel = newIdent(f.target.symbol("Info"), selexpr.Sel.Pos())
+	callexpr.Args = f.rewriteArgs(callexpr)
 }
 
 func (f *logrFix) fixError(selexpr *ast.SelectorExpr, callexpr *ast.CallExpr) {
-	selexpr.Sel = newIdent("Error", selexpr.Sel.Pos())
+	selexpr.Sel = newIdent(f.target.symbol("Error"), selexpr.Sel.Pos())
 
-	// Look for the best arg to use as the error.
-	isErrorType := []int{}
-	isNamedErr := -1
-	for i, arg := range callexpr.Args {
-		t := f.loader.TypeInfo().Types[arg].Type
-		f.log.V(5).Info("arg", "idx", i, "type", t.String())
-		if types.Implements(t, f.errorInterface) {
-			isErrorType = append(isErrorType, i)
-		}
-	}
-	errIndex := -1
-	if len(isErrorType) != 0 {
-		if len(isErrorType) > 1 {
-			//FIXME: print file and line
-			fmt.Fprintf(os.Stderr, "WARNING: more than one argument has type `error`\n")
-		}
-		errIndex = isErrorType[0]
-	} else if isNamedErr >= 0 {
-		errIndex = isNamedErr
-	}
+	errIndex := f.findErrorArg(callexpr)
 	errExpr := "FIXME__unknown_error_expr"
 	if errIndex >= 0 {
 		// Remember the expression to emit later and remove it from the args list.
@@ -417,36 +496,117 @@ func (f *logrFix) fixError(selexpr *ast.SelectorExpr, callexpr *ast.CallExpr) {
 		callexpr.Args = append(callexpr.Args[:errIndex], callexpr.Args[errIndex+1:]...)
 	}
 
-	newArgs := []ast.Expr{ast.NewIdent(errExpr), getFormatString(callexpr.Args)}
-	// Generate the key-value args.
+	kvArgs := f.rewriteArgs(callexpr)
+	callexpr.Args = append([]ast.Expr{ast.NewIdent(errExpr)}, kvArgs...)
+}
+
+// findErrorArg picks the argument to use as the error, among every argument
+// whose static type satisfies the error interface (so a wrapped error, a
+// named type implementing error, or a pointer receiver all qualify, not just
+// a bare identifier typed exactly `error`). When more than one argument
+// qualifies, it prefers the one whose static type is exactly `error`, then
+// the one named "err", and otherwise records a diagnostic at the call site
+// and picks the first candidate rather than silently guessing.
+func (f *logrFix) findErrorArg(callexpr *ast.CallExpr) int {
+	var candidates []int
 	for i, arg := range callexpr.Args {
-		if i == 0 {
+		t := f.loader.TypeInfo().Types[arg].Type
+		if t == nil {
 			continue
 		}
-		key := `"FIXME__unknown_key"`
-		if ident, ok := arg.(*ast.Ident); ok {
-			key = `"` + ident.Name + `"`
+		f.log.V(5).Info("arg", "idx", i, "type", t.String())
+		if types.Implements(t, f.errorInterface) || types.AssignableTo(t, f.errorInterface) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return -1
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	for _, i := range candidates {
+		if types.Identical(f.loader.TypeInfo().Types[callexpr.Args[i]].Type, universeErrorType) {
+			return i
+		}
+	}
+	for _, i := range candidates {
+		if id, ok := callexpr.Args[i].(*ast.Ident); ok && id.Name == "err" {
+			return i
+		}
+	}
+
+	if f.info.Diag != nil {
+		f.info.Diag(callexpr.Pos(), "ambiguous error argument: more than one argument implements error")
+	}
+	return candidates[0]
+}
+
+// rewriteArgs turns a klog/glog-style (format, args...) call into a logr
+// (message, key, value, key, value...) arg list.  It parses the leading
+// format-string literal for "key=%v"/"key: %s"-shaped fragments and, for
+// any argument that doesn't land on one of those, falls back to inferring a
+// key from the argument expression itself via the type checker.  Calls with
+// no leading string literal -- klog.Error(err), klog.Info(obj),
+// klog.Errorf(fmt.Sprintf(...), ...) -- have no format string to parse, so
+// every remaining argument is treated as a bare value to key-infer.
+func (f *logrFix) rewriteArgs(callexpr *ast.CallExpr) []ast.Expr {
+	format := ""
+	rest := callexpr.Args
+	if lit := getFormatString(callexpr.Args); lit != nil {
+		var err error
+		format, err = strconv.Unquote(lit.Value)
+		if err != nil {
+			format = lit.Value
+		}
+		rest = callexpr.Args[1:]
+	}
+	parsed := parseFormatString(format, len(rest))
+
+	newArgs := []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(parsed.Message)}}
+	for i, arg := range rest {
+		key := ""
+		if i < len(parsed.Keys) {
+			key = parsed.Keys[i]
+		}
+		if key == "" {
+			key = inferKey(arg, f.loader.TypeInfo())
 		}
-		newArgs = append(newArgs, &ast.BasicLit{Kind: token.STRING, Value: key}, arg)
+		if key == "" {
+			key = fmt.Sprintf("arg%d", i+1)
+			f.fixmeKeys++
+		} else {
+			f.inferredKeys++
+		}
+		newArgs = append(newArgs, &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(key)}, arg)
 	}
-	callexpr.Args = newArgs
+
+	return newArgs
 }
 
-func fixInitFlags(selexpr *ast.SelectorExpr) {
-	// This will break, which is what we want.  A human needs to look at this.
-	selexpr.Sel = newIdent("FIXME__InitFlags_is_not_supported", selexpr.Sel.Pos())
+func (f *logrFix) fixInitFlags(selexpr *ast.SelectorExpr) {
+	// Unless the target config gives us something to call instead, this
+	// will break, which is what we want.  A human needs to look at this.
+	name := f.target.symbol("InitFlags")
+	if name == "InitFlags" {
+		name = "FIXME__InitFlags_is_not_supported"
+	}
+	selexpr.Sel = newIdent(name, selexpr.Sel.Pos())
 }
 
+// getFormatString returns the leading string-literal format argument, or nil
+// if there are no arguments left (e.g. klog.Error(err) once the error arg is
+// removed) or the leading argument isn't a string literal (e.g. klog.Info(obj)
+// or klog.Errorf(fmt.Sprintf(...), ...)).  Callers fall back to an empty
+// message rather than failing the whole fix over an unparseable call.
 func getFormatString(args []ast.Expr) *ast.BasicLit {
 	if len(args) == 0 {
-		panic("No call arguments found")
+		return nil
 	}
 	lit, ok := args[0].(*ast.BasicLit)
-	if !ok {
-		panic("First call argument is not a literal")
-	}
-	if lit.Kind != token.STRING {
-		panic("First call argument is not a string")
+	if !ok || lit.Kind != token.STRING {
+		return nil
 	}
 	return lit
 }
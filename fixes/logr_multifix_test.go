@@ -0,0 +1,146 @@
+package fixes
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/thockin/klog-to-logr/fixer"
+)
+
+// mapImporter resolves the two fake source packages used by
+// TestFixPackage_MultipleImportsInOneFile below, for the single initial
+// typecheck of the root file.
+type mapImporter map[string]*types.Package
+
+func (m mapImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := m[path]; ok {
+		return pkg, nil
+	}
+	return nil, fmt.Errorf("no such package %q", path)
+}
+
+// checkPackage type-checks src as the named package, with the given
+// importer, and returns the resulting file, *types.Package and *types.Info.
+func checkPackage(t *testing.T, fset *token.FileSet, name, src string, imp types.Importer) (*ast.File, *types.Package, *types.Info) {
+	t.Helper()
+	file, err := parser.ParseFile(fset, name+".go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", name, err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	cfg := &types.Config{Importer: imp}
+	tpkg, err := cfg.Check(name, fset, []*ast.File{file}, info)
+	if err != nil {
+		t.Fatalf("typechecking %s: %v", name, err)
+	}
+	return file, tpkg, info
+}
+
+// TestFixPackage_MultipleImportsInOneFile covers a file that imports two
+// source packages that each get their own LogrFix in the pipeline (as
+// main.go does for one -from-pkg per klog/klog-v2/glog default). The second
+// fix's type-directed findErrorArg lookup must still resolve correctly
+// against the first fix's rewritten AST, which only works if Fixer
+// re-typechecks the package after the first (NeedsRetypecheck) fix reparses
+// the file -- otherwise the second fix's type lookups miss against the new
+// AST's node identities and it falls back to the "ambiguous"/unknown-error
+// placeholder.
+func TestFixPackage_MultipleImportsInOneFile(t *testing.T) {
+	fset := token.NewFileSet()
+
+	pkgAFile, pkgAType, _ := checkPackage(t, fset, "pkgA", `package pkgA
+func Error(args ...interface{}) {}
+`, nil)
+
+	pkgBFile, pkgBType, _ := checkPackage(t, fset, "pkgB", `package pkgB
+func Error(args ...interface{}) {}
+`, nil)
+
+	// Stands in for the logr.Logger-shaped target package every LogrFix
+	// imports into a fixed file: it's not part of the root package's
+	// original import graph, so retypechecking after each fix needs
+	// Fixer.ExtraImports to resolve it.
+	_, targetType, _ := checkPackage(t, fset, "target", `package target
+func Error(args ...interface{}) {}
+`, nil)
+
+	const src = `package p
+
+import (
+	"pkgA"
+	"pkgB"
+)
+
+func f(e error) {
+	pkgA.Error("first", e)
+	pkgB.Error("second", e)
+}
+`
+	rootFile, rootType, rootInfo := checkPackage(t, fset, "p", src, mapImporter{
+		"pkgA": pkgAType,
+		"pkgB": pkgBType,
+	})
+
+	pkg := &packages.Package{
+		ID:              "p",
+		PkgPath:         "p",
+		Fset:            fset,
+		Syntax:          []*ast.File{rootFile},
+		CompiledGoFiles: []string{"p.go"},
+		Types:           rootType,
+		TypesInfo:       rootInfo,
+		Imports: map[string]*packages.Package{
+			"pkgA": {ID: "pkgA", PkgPath: "pkgA", Types: pkgAType, Syntax: []*ast.File{pkgAFile}},
+			"pkgB": {ID: "pkgB", PkgPath: "pkgB", Types: pkgBType, Syntax: []*ast.File{pkgBFile}},
+		},
+	}
+
+	target := TargetConfig{ImportPath: "target", PackageIdent: "log"}
+	fixA, err := LogrFix("pkgA", target)
+	if err != nil {
+		t.Fatalf("LogrFix(pkgA): %v", err)
+	}
+	fixB, err := LogrFix("pkgB", target)
+	if err != nil {
+		t.Fatalf("LogrFix(pkgB): %v", err)
+	}
+
+	var out []byte
+	fx := &fixer.Fixer{
+		Log:          logr.Discard(),
+		Fixes:        []fixer.Fix{fixA, fixB},
+		ExtraImports: map[string]*types.Package{"target": targetType},
+		HandleFix: func(info fixer.FileInfo) error {
+			src, err := fixer.GofmtFile(info.AST, info.Fset)
+			out = src
+			return err
+		},
+	}
+
+	if err := fx.FixPackage(pkg); err != nil {
+		t.Fatalf("FixPackage: %v", err)
+	}
+
+	got := string(out)
+	if strings.Contains(got, "FIXME__unknown_error_expr") {
+		t.Errorf("second fix lost type info after the first fix's reparse; got:\n%s", got)
+	}
+	if !strings.Contains(got, `log.Error(e, "first")`) {
+		t.Errorf("first call not rewritten as expected; got:\n%s", got)
+	}
+	if !strings.Contains(got, `log.Error(e, "second")`) {
+		t.Errorf("second call not rewritten as expected; got:\n%s", got)
+	}
+}
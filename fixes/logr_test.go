@@ -0,0 +1,225 @@
+package fixes
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/thockin/klog-to-logr/importer"
+)
+
+// stubLoader is a minimal importer.Loader that just serves up type info
+// from a single types.Check call, for exercising logrFix methods that only
+// need f.loader.TypeInfo().
+type stubLoader struct {
+	info *types.Info
+}
+
+func (l stubLoader) PackageInfoFor(path string) *importer.PackageInfo { return nil }
+func (l stubLoader) TypeInfo() *types.Info                            { return l.info }
+
+// typecheck parses and type-checks src (a complete, import-free source
+// file) and returns the file and the resulting type info.
+func typecheck(t *testing.T, src string) (*ast.File, *types.Info) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, 0)
+	if err != nil {
+		t.Fatalf("parsing test source: %v", err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+	}
+	conf := types.Config{}
+	if _, err := conf.Check("p", fset, []*ast.File{file}, info); err != nil {
+		t.Fatalf("typechecking test source: %v", err)
+	}
+	return file, info
+}
+
+// findTargetCall locates the unique call to a function named "target" in
+// file, which every typecheck fixture below uses as the call under test.
+func findTargetCall(file *ast.File) *ast.CallExpr {
+	var found *ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "target" {
+				found = call
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// findErrorCall locates the unique call to a selector named "Error" (e.g.
+// klog.Error(...)) in file, for fixtures that need a genuine
+// *ast.SelectorExpr call rather than the bare-identifier calls
+// findTargetCall looks for.
+func findErrorCall(file *ast.File) *ast.CallExpr {
+	var found *ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Error" {
+				found = call
+			}
+		}
+		return true
+	})
+	return found
+}
+
+func TestFindErrorArg(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{
+			name: "single candidate",
+			src: `package p
+func target(args ...interface{}) {}
+func f(e error) {
+	target(e)
+}
+`,
+			want: 0,
+		},
+		{
+			name: "prefers exact error type over a type that merely implements it",
+			src: `package p
+type myErr struct{}
+func (myErr) Error() string { return "" }
+func target(args ...interface{}) {}
+func f(e error, me myErr) {
+	target(me, e)
+}
+`,
+			want: 1,
+		},
+		{
+			name: "falls back to an arg named err when none is exactly error",
+			src: `package p
+type myErr struct{}
+func (myErr) Error() string { return "" }
+type myErr2 struct{}
+func (myErr2) Error() string { return "" }
+func target(args ...interface{}) {}
+func f(err myErr, other myErr2) {
+	target(other, err)
+}
+`,
+			want: 1,
+		},
+		{
+			name: "picks the first candidate when ambiguous",
+			src: `package p
+type myErr struct{}
+func (myErr) Error() string { return "" }
+type myErr2 struct{}
+func (myErr2) Error() string { return "" }
+func target(args ...interface{}) {}
+func f(a myErr, b myErr2) {
+	target(a, b)
+}
+`,
+			want: 0,
+		},
+		{
+			name: "no candidates",
+			src: `package p
+func target(args ...interface{}) {}
+func f(x int, y string) {
+	target(x, y)
+}
+`,
+			want: -1,
+		},
+	}
+
+	errIface, err := loadErrorType()
+	if err != nil {
+		t.Fatalf("loadErrorType: %v", err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			file, info := typecheck(t, tc.src)
+			callexpr := findTargetCall(file)
+			if callexpr == nil {
+				t.Fatalf("no call to target() found in test source")
+			}
+
+			fix := &logrFix{
+				log:    logr.Discard(),
+				loader: stubLoader{info: info},
+				logrFixMaker: &logrFixMaker{
+					errorInterface: errIface,
+				},
+			}
+
+			if got := fix.findErrorArg(callexpr); got != tc.want {
+				t.Errorf("findErrorArg() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestFixErrorNoFormatString covers calls whose only argument is the error
+// itself -- klog.Error(err) is the most common shape of Error call in the
+// wild -- which leaves findErrorArg's removal with no leading format-string
+// literal for rewriteArgs to parse.  fixError must fall back to an empty
+// message instead of panicking.
+func TestFixErrorNoFormatString(t *testing.T) {
+	fset := token.NewFileSet()
+	_, klogType, _ := checkPackage(t, fset, "klog", `package klog
+func Error(args ...interface{}) {}
+`, nil)
+
+	file, _, info := checkPackage(t, fset, "p", `package p
+
+import "klog"
+
+func f(err error) {
+	klog.Error(err)
+}
+`, mapImporter{"klog": klogType})
+
+	callexpr := findErrorCall(file)
+	if callexpr == nil {
+		t.Fatalf("no call to klog.Error() found in test source")
+	}
+
+	errIface, err := loadErrorType()
+	if err != nil {
+		t.Fatalf("loadErrorType: %v", err)
+	}
+
+	fix := &logrFix{
+		log:    logr.Discard(),
+		loader: stubLoader{info: info},
+		logrFixMaker: &logrFixMaker{
+			target:         TargetConfig{PackageIdent: "log"},
+			errorInterface: errIface,
+		},
+	}
+
+	selexpr := callexpr.Fun.(*ast.SelectorExpr)
+	fix.fixError(selexpr, callexpr)
+
+	if len(callexpr.Args) != 2 {
+		t.Fatalf("Args = %+v, want [err, \"\"]", callexpr.Args)
+	}
+	msg, uerr := strconv.Unquote(callexpr.Args[1].(*ast.BasicLit).Value)
+	if uerr != nil {
+		t.Fatalf("unquoting message: %v", uerr)
+	}
+	if msg != "" {
+		t.Errorf("message = %q, want empty", msg)
+	}
+}
@@ -0,0 +1,68 @@
+package fixes
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRewriteArgsFallsBackToPositionalKey(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", `package p
+func target(format string, args ...interface{}) {}
+func f() {
+	target("hello %d", 1+2)
+}
+`, 0)
+	if err != nil {
+		t.Fatalf("parsing test source: %v", err)
+	}
+
+	var callexpr *ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "target" {
+				callexpr = call
+			}
+		}
+		return true
+	})
+	if callexpr == nil {
+		t.Fatalf("no call to target() found in test source")
+	}
+
+	fix := &logrFix{
+		log:          logr.Discard(),
+		loader:       stubLoader{info: &types.Info{}},
+		logrFixMaker: &logrFixMaker{},
+	}
+
+	args := fix.rewriteArgs(callexpr)
+
+	// [message, "arg1", <1+2 expr>]
+	if len(args) != 3 {
+		t.Fatalf("rewriteArgs() returned %d args, want 3: %+v", len(args), args)
+	}
+	msg, err := strconv.Unquote(args[0].(*ast.BasicLit).Value)
+	if err != nil {
+		t.Fatalf("unquoting message: %v", err)
+	}
+	if msg != "hello" {
+		t.Errorf("message = %q, want %q", msg, "hello")
+	}
+	key, err := strconv.Unquote(args[1].(*ast.BasicLit).Value)
+	if err != nil {
+		t.Fatalf("unquoting key: %v", err)
+	}
+	if key != "arg1" {
+		t.Errorf("key = %q, want %q (no inferrable key for a binary expression)", key, "arg1")
+	}
+	if fix.fixmeKeys != 1 || fix.inferredKeys != 0 {
+		t.Errorf("fixmeKeys=%d inferredKeys=%d, want 1, 0", fix.fixmeKeys, fix.inferredKeys)
+	}
+}
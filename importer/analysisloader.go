@@ -0,0 +1,43 @@
+package importer
+
+import (
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// AnalysisLoader is a Loader backed by a golang.org/x/tools/go/analysis.Pass,
+// letting a fixer.Fix run under the analysis framework (e.g. `go vet
+// -vettool=`, gopls, or singlechecker/multichecker) without changing its
+// type-lookup code.
+type AnalysisLoader struct {
+	pass *analysis.Pass
+}
+
+// FromAnalysisPass wraps pass as a Loader.
+func FromAnalysisPass(pass *analysis.Pass) *AnalysisLoader {
+	return &AnalysisLoader{pass: pass}
+}
+
+func (l *AnalysisLoader) PackageInfoFor(path string) *PackageInfo {
+	pkg := l.pass.Pkg
+	if pkg.Path() != path {
+		pkg = nil
+		for _, imp := range l.pass.Pkg.Imports() {
+			if imp.Path() == path {
+				pkg = imp
+				break
+			}
+		}
+		if pkg == nil {
+			return nil
+		}
+	}
+	return &PackageInfo{ParseInfo: pkg}
+}
+
+// TypeInfo returns the *types.Info the analysis framework already computed
+// for this pass.
+func (l *AnalysisLoader) TypeInfo() *types.Info {
+	return l.pass.TypesInfo
+}
@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/packages"
+)
+
+// CacheMode controls how an ExportCache is consulted and updated.
+type CacheMode int
+
+const (
+	// CacheOff disables the cache entirely: every dependency's type info
+	// comes straight from what packages.Load already computed in memory.
+	CacheOff CacheMode = iota
+	// CacheReadOnly serves cache hits but never writes new entries.
+	CacheReadOnly
+	// CacheReadWrite serves cache hits and persists new results.
+	CacheReadWrite
+)
+
+// ExportCache is an on-disk cache of gcexportdata-encoded *types.Package
+// values for imported dependency packages, keyed on the dependency's import
+// path, the version of the module it came from, the build tags in effect,
+// and a hash of its compiled source files. It lets a PackageLoader skip
+// re-deserializing a dependency's export data across repeated kfix runs
+// against the same module version.
+//
+// A single ExportCache is shared across the worker pool main.go runs one
+// root package's FixPackage per goroutine in, so any two of them sharing a
+// common dependency -- a stdlib package, a common vendored type -- would
+// otherwise Get/Put the same cache file from different goroutines at once.
+// mu serializes all access, and Put writes through a temp file so a reader
+// never observes a partially-written entry.
+type ExportCache struct {
+	Dir  string
+	Mode CacheMode
+
+	mu sync.Mutex
+}
+
+// buildTagKey identifies the build configuration (GOOS/GOARCH plus any
+// active release or custom tags) that a cached entry was produced under, so
+// a later run under a different configuration doesn't reuse it.
+func buildTagKey() string {
+	key := runtime.GOOS + "/" + runtime.GOARCH
+	for _, tag := range build.Default.BuildTags {
+		key += "," + tag
+	}
+	for _, tag := range build.Default.ReleaseTags {
+		key += "," + tag
+	}
+	return key
+}
+
+func cacheKeyFor(pkg *packages.Package) (string, bool) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", pkg.PkgPath)
+	if pkg.Module != nil {
+		fmt.Fprintf(h, "%s@%s\x00", pkg.Module.Path, pkg.Module.Version)
+	}
+	fmt.Fprintf(h, "%s\x00", buildTagKey())
+	for _, f := range pkg.CompiledGoFiles {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			return "", false
+		}
+		sum := sha256.Sum256(src)
+		h.Write(sum[:])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (c *ExportCache) path(key string) string {
+	return filepath.Join(c.Dir, key)
+}
+
+// Get deserializes a previously-cached *types.Package for dep, resolving
+// any packages dep imports via imports. ok is false on any cache miss.
+func (c *ExportCache) Get(fset *token.FileSet, dep *packages.Package, imports map[string]*types.Package) (tpkg *types.Package, ok bool) {
+	if c == nil || c.Mode == CacheOff {
+		return nil, false
+	}
+	key, ok := cacheKeyFor(dep)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	tpkg, err = gcexportdata.Read(f, fset, imports, dep.PkgPath)
+	if err != nil {
+		return nil, false
+	}
+	return tpkg, true
+}
+
+// Put persists dep's type information for later reuse by Get. It is a
+// no-op under CacheOff and CacheReadOnly.
+//
+// It writes through a temp file in c.Dir and renames it into place so a
+// concurrent Get never observes a partially-written entry.
+func (c *ExportCache) Put(fset *token.FileSet, dep *packages.Package) error {
+	if c == nil || c.Mode != CacheReadWrite {
+		return nil
+	}
+	key, ok := cacheKeyFor(dep)
+	if !ok {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(c.Dir, key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gcexportdata.Write(tmp, fset, dep.Types); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// Clean removes every entry from the cache.
+func (c *ExportCache) Clean() error {
+	if c == nil || c.Dir == "" {
+		return nil
+	}
+	return os.RemoveAll(c.Dir)
+}
@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// depPackage parses and typechecks src as a package at path (a real file on
+// disk, since cacheKeyFor hashes CompiledGoFiles), returning a
+// *packages.Package shaped like what a PackageLoader hands ExportCache.
+func depPackage(t *testing.T, fset *token.FileSet, dir, src string) *packages.Package {
+	t.Helper()
+	path := filepath.Join(dir, "dep.go")
+	if err := ioutil.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("writing source fixture: %v", err)
+	}
+	file, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	conf := types.Config{}
+	tpkg, err := conf.Check("dep", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("typechecking %s: %v", path, err)
+	}
+	return &packages.Package{
+		PkgPath:         "dep",
+		CompiledGoFiles: []string{path},
+		Types:           tpkg,
+	}
+}
+
+func TestExportCacheGetPutRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	dep := depPackage(t, fset, dir, "package dep\n\nfunc F() {}\n")
+
+	c := &ExportCache{Dir: filepath.Join(dir, "cache"), Mode: CacheReadWrite}
+	if err := c.Put(fset, dep); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, ok := c.Get(token.NewFileSet(), dep, map[string]*types.Package{})
+	if !ok {
+		t.Fatalf("Get: cache miss, want hit")
+	}
+	if got.Path() != "dep" {
+		t.Errorf("Path() = %q, want %q", got.Path(), "dep")
+	}
+	if got.Scope().Lookup("F") == nil {
+		t.Errorf("round-tripped package is missing func F")
+	}
+}
+
+func TestExportCacheGetMissOnChangedSource(t *testing.T) {
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	dep := depPackage(t, fset, dir, "package dep\n\nfunc F() {}\n")
+
+	c := &ExportCache{Dir: filepath.Join(dir, "cache"), Mode: CacheReadWrite}
+	if err := c.Put(fset, dep); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Same import path, different file contents: cacheKeyFor hashes
+	// CompiledGoFiles, so this must be a fresh key, not the entry above.
+	changed := depPackage(t, token.NewFileSet(), dir, "package dep\n\nfunc F() {}\nfunc G() {}\n")
+	if _, ok := c.Get(token.NewFileSet(), changed, map[string]*types.Package{}); ok {
+		t.Errorf("Get: cache hit after source changed, want miss")
+	}
+}
+
+func TestExportCacheReadOnlyDoesNotWrite(t *testing.T) {
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	dep := depPackage(t, fset, dir, "package dep\n\nfunc F() {}\n")
+
+	c := &ExportCache{Dir: filepath.Join(dir, "cache"), Mode: CacheReadOnly}
+	if err := c.Put(fset, dep); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := c.Get(token.NewFileSet(), dep, map[string]*types.Package{}); ok {
+		t.Errorf("Get: cache hit under CacheReadOnly after a Put, want miss")
+	}
+}
+
+func TestExportCacheOffDoesNotWrite(t *testing.T) {
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	dep := depPackage(t, fset, dir, "package dep\n\nfunc F() {}\n")
+
+	c := &ExportCache{Dir: filepath.Join(dir, "cache"), Mode: CacheOff}
+	if err := c.Put(fset, dep); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := c.Get(token.NewFileSet(), dep, map[string]*types.Package{}); ok {
+		t.Errorf("Get: cache hit under CacheOff, want miss")
+	}
+}
+
+func TestExportCacheNilIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	dep := depPackage(t, fset, dir, "package dep\n\nfunc F() {}\n")
+
+	var c *ExportCache
+	if err := c.Put(fset, dep); err != nil {
+		t.Fatalf("Put on nil *ExportCache: %v", err)
+	}
+	if _, ok := c.Get(token.NewFileSet(), dep, map[string]*types.Package{}); ok {
+		t.Errorf("Get on nil *ExportCache: hit, want miss")
+	}
+}
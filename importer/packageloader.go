@@ -0,0 +1,79 @@
+package importer
+
+import (
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// PackageLoader is a Loader backed directly by the result of a
+// golang.org/x/tools/go/packages.Load call. It does no parsing or
+// typechecking of its own -- packages.Load already did that, including
+// module resolution, build tags, cgo, and test/xtest variants.
+type PackageLoader struct {
+	pkg   *packages.Package
+	cache *ExportCache
+}
+
+// FromPackage wraps an already-loaded *packages.Package as a Loader, with no
+// export-data cache.
+func FromPackage(pkg *packages.Package) *PackageLoader {
+	return &PackageLoader{pkg: pkg}
+}
+
+// FromPackageWithCache is like FromPackage, but consults and populates cache
+// for the type information of pkg's dependencies. cache may be nil, in which
+// case it behaves exactly like FromPackage.
+func FromPackageWithCache(pkg *packages.Package, cache *ExportCache) *PackageLoader {
+	return &PackageLoader{pkg: pkg, cache: cache}
+}
+
+func (l *PackageLoader) PackageInfoFor(path string) *PackageInfo {
+	pkg := l.pkg
+	if path != pkg.PkgPath {
+		dep, ok := pkg.Imports[path]
+		if !ok {
+			return nil
+		}
+		pkg = dep
+	}
+	return &PackageInfo{
+		ParseInfo: l.typesPackage(pkg),
+		Files:     pkg.Syntax,
+	}
+}
+
+// typesPackage returns pkg's *types.Package, consulting and populating
+// l.cache first for anything other than the root package the loader was
+// built from: packages.Load already typechecked the root in this process,
+// so there's nothing to gain caching that one.
+func (l *PackageLoader) typesPackage(pkg *packages.Package) *types.Package {
+	if l.cache == nil || pkg == l.pkg {
+		return pkg.Types
+	}
+
+	imports := make(map[string]*types.Package, len(pkg.Imports))
+	for path, dep := range pkg.Imports {
+		imports[path] = l.typesPackage(dep)
+	}
+
+	if tpkg, ok := l.cache.Get(l.pkg.Fset, pkg, imports); ok {
+		return tpkg
+	}
+	// Best-effort: a write failure just means the next run doesn't get a
+	// cache hit, same as if this one had missed too.
+	l.cache.Put(l.pkg.Fset, pkg)
+	return pkg.Types
+}
+
+// TypeInfo returns the *types.Info produced by packages.Load (with
+// NeedTypesInfo) for the wrapped package.
+func (l *PackageLoader) TypeInfo() *types.Info {
+	return l.pkg.TypesInfo
+}
+
+// Clean removes every entry from the loader's export-data cache. It is a
+// no-op if the loader was constructed without one.
+func (l *PackageLoader) Clean() error {
+	return l.cache.Clean()
+}
@@ -7,222 +7,278 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/build"
-	"go/format"
-	"go/importer"
-	"go/parser"
-	"go/token"
 	"go/types"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"sort"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-logr/glogr"
 	"github.com/go-logr/logr"
-	"k8s.io/klog/glog"
-)
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/multichecker"
+	"golang.org/x/tools/go/packages"
 
-var (
-	fset     = token.NewFileSet()
-	typeInfo = &types.Info{
-		Types: make(map[ast.Expr]types.TypeAndValue),
-		Defs:  make(map[*ast.Ident]types.Object),
-	}
+	"github.com/thockin/klog-to-logr/fixer"
+	"github.com/thockin/klog-to-logr/fixes"
+	"github.com/thockin/klog-to-logr/importer"
 )
 
-// FIXME: we probably don't need all this registration stuff.  Better to be a purpose-built tool.
-type Fix struct {
-	name string
-	fn   func(string, *ast.File) bool
-	desc string
-}
+var doDiff = flag.Bool("diff", false, "print diffs instead of rewriting files")
+var doVet = flag.Bool("vet", false, "run as a go/analysis driver (singlechecker/multichecker, go vet -vettool=, gopls) instead of rewriting files directly")
 
-type byName []Fix
+// stringSlice is a flag.Value that collects repeated occurrences of a flag
+// into a slice, in the order given.
+type stringSlice []string
 
-func (f byName) Len() int           { return len(f) }
-func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
-func (f byName) Less(i, j int) bool { return f[i].name < f[j].name }
+func (s *stringSlice) String() string { return fmt.Sprint([]string(*s)) }
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
 
-var allFixes []Fix
+var (
+	fromPkgs stringSlice
+	// The target package needs two separate flags, not one --to-pkg,
+	// because an import path and the identifier used for it at call sites
+	// are independent: "sigs.k8s.io/controller-runtime/pkg/log" is commonly
+	// imported as "log", but nothing says a target package's identifier has
+	// to match the last element of its import path.
+	toImport  = flag.String("to-import", fixes.DefaultTarget.ImportPath, "import path of the logr.Logger-shaped package to rewrite calls to")
+	toIdent   = flag.String("to", fixes.DefaultTarget.PackageIdent, "identifier used for the target package at call sites, e.g. \"log\" for -to-import=sigs.k8s.io/controller-runtime/pkg/log")
+	cacheMode = flag.String("cache", "rw", "rewrite cache mode: off, ro, or rw")
+	cacheDir  = flag.String("cache-dir", fixer.DefaultCacheDir(), "directory for the rewrite cache")
+)
 
-func register(f Fix) {
-	allFixes = append(allFixes, f)
+func init() {
+	flag.Var(&fromPkgs, "from-pkg", "klog/glog import path to rewrite from; may be repeated (default k8s.io/klog, k8s.io/klog/v2, and github.com/golang/glog)")
 }
 
-var doDiff = flag.Bool("diff", false, "print diffs instead of rewriting files")
-
-func usage() {
-	fmt.Fprintf(os.Stderr, "usage: kfix [-diff] [path ...]\n")
-	flag.PrintDefaults()
-	fmt.Fprintf(os.Stderr, "\nAvailable fixups are:\n")
-	sort.Sort(byName(allFixes))
-	for _, f := range allFixes {
-		fmt.Fprintf(os.Stderr, "\n%s\n", f.name)
-		desc := strings.TrimSpace(f.desc)
-		desc = strings.Replace(desc, "\n", "\n\t", -1)
-		fmt.Fprintf(os.Stderr, "\t%s\n", desc)
+func parseCacheMode(log logr.Logger, mode string) fixer.CacheMode {
+	switch mode {
+	case "off":
+		return fixer.CacheOff
+	case "ro":
+		return fixer.CacheReadOnly
+	case "rw":
+		return fixer.CacheReadWrite
+	default:
+		log.Error(nil, "unknown -cache mode, disabling cache", "mode", mode)
+		return fixer.CacheOff
 	}
-	os.Exit(93)
 }
 
-type Package struct {
-	Name      string
-	ASTFiles  []*ast.File
-	Filenames []string
+// parseExportCacheMode mirrors parseCacheMode for the export-data cache,
+// which uses the same off/ro/rw vocabulary as -cache.
+func parseExportCacheMode(log logr.Logger, mode string) importer.CacheMode {
+	switch mode {
+	case "off":
+		return importer.CacheOff
+	case "ro":
+		return importer.CacheReadOnly
+	case "rw":
+		return importer.CacheReadWrite
+	default:
+		log.Error(nil, "unknown -cache mode, disabling export cache", "mode", mode)
+		return importer.CacheOff
+	}
 }
 
-// Global logger.
-var log logr.Logger
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: kfix [-diff] [-from-pkg pkg ...] [-to ident] [-to-import path] pattern [pattern ...]\n")
+	fmt.Fprintf(os.Stderr, "\tpatterns follow `go list` syntax: files, dirs, import paths, or ./...\n")
+	fmt.Fprintf(os.Stderr, "\t-to and -to-import both describe the target package: -to-import is its\n")
+	fmt.Fprintf(os.Stderr, "\timport path, -to is the identifier calls are rewritten to use for it.\n")
+	flag.PrintDefaults()
+	os.Exit(93)
+}
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	log = glogr.New()
-	defer glog.Flush()
+	log := glogr.New()
 
 	if flag.NArg() == 0 {
 		usage()
 	}
 
-	//FIXME: suport foo.com/repo/pkg/... syntax
-	for i := 0; i < flag.NArg(); i++ {
-		arg := flag.Arg(i)
-		bldpkg, err := build.Import(arg, ".", 0)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "can't fix %q: %v\n", arg, err)
-			os.Exit(1)
-		}
-		pkg := &Package{Name: arg}
-		conf := types.Config{Importer: importer.Default()} //FIXME: this is looking for .a dirs
-		//conf := types.Config{Importer: buildImporter{}} //FIXME: fails because it didn't parse fmt
-		dir, err := filepath.Abs(bldpkg.Dir)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "can't get absolute path for pkg-dir %q: %v\n", bldpkg.Dir, err)
-			os.Exit(2)
-		}
-		for _, filename := range append(append([]string{}, bldpkg.GoFiles...), bldpkg.TestGoFiles...) {
-			path := filepath.Join(dir, filename)
-			ast, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "can't parse %q: %v\n", path, err)
-				os.Exit(3)
-			}
-			pkg.ASTFiles = append(pkg.ASTFiles, ast)
-			pkg.Filenames = append(pkg.Filenames, path)
-		}
-		_, err = conf.Check(".", fset, pkg.ASTFiles, typeInfo)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "can't typecheck %q: %v\n", arg, err)
-			os.Exit(4)
-		}
-		log.V(2).Info("processing package", "pkg", bldpkg.Dir)
-		if err := doPkg(pkg); err != nil {
-			fmt.Fprintf(os.Stderr, "aborting package %q: %v\n", arg, err)
-			os.Exit(5)
-		}
+	if len(fromPkgs) == 0 {
+		fromPkgs = stringSlice{fixes.StandardKlogPkg, fixes.StandardKlogV2Pkg, fixes.StandardGlogPkg}
 	}
+	target := fixes.TargetConfig{ImportPath: *toImport, PackageIdent: *toIdent}
 
-	os.Exit(0)
-}
-
-func gofmtFile(f *ast.File) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := format.Node(&buf, fset, f); err != nil {
-		return nil, err
+	var logrFixes []fixer.Fix
+	for _, pkg := range fromPkgs {
+		logrFixes = append(logrFixes, fixes.Must(fixes.LogrFix(pkg, target)))
 	}
-	return buf.Bytes(), nil
-}
 
-type buildImporter struct{}
+	if *doVet {
+		// Hand off to the analysis driver entirely: it does its own flag
+		// parsing and pattern loading, reporting each rewrite as a
+		// suggested fix instead of writing to disk.
+		var analyzers []*analysis.Analyzer
+		for _, fix := range logrFixes {
+			analyzers = append(analyzers, fixer.AsAnalyzer(fix))
+		}
+		multichecker.Main(analyzers...)
+		return
+	}
 
-func (bi buildImporter) Import(path string) (*types.Package, error) {
-	return bi.ImportFrom(path, "", 0)
-}
-func (buildImporter) ImportFrom(path, src string, mode types.ImportMode) (*types.Package, error) {
-	//FIXME: if we use this mode, save a cache for dups
-	bp, err := build.Import(path, src, 0) // build.FindOnly here and other?
+	// Every logrFix adds an import of target.ImportPath to a file it
+	// touches, whether or not that file (or any other in the package
+	// graph below) imported it already. Load its type information once,
+	// up front, so retypechecking after a fix can resolve that import too
+	// -- packages.Load below only walks what the CLI patterns and their
+	// existing imports reach, which usually doesn't include the target.
+	targetPkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName | packages.NeedTypes | packages.NeedDeps | packages.NeedImports}, target.ImportPath)
 	if err != nil {
-		return nil, err
+		log.Error(err, "failed to load target package", "pkg", target.ImportPath)
+		os.Exit(2)
+	}
+	// packages.Load returns a nil err even when target.ImportPath itself
+	// didn't resolve -- it comes back as a placeholder *packages.Package
+	// with Errors populated instead. Left unchecked, that placeholder's
+	// empty Types feeds into extraImports below and the failure only
+	// surfaces later, once per touched file, as a cryptic retypecheck
+	// error instead of one clear diagnostic naming the bad flag.
+	targetFailed := false
+	for _, p := range targetPkgs {
+		for _, e := range p.Errors {
+			log.Error(e, "target package load error", "pkg", target.ImportPath)
+			targetFailed = true
+		}
+	}
+	if targetFailed {
+		os.Exit(2)
+	}
+	extraImports := map[string]*types.Package{}
+	for _, p := range targetPkgs {
+		extraImports[p.PkgPath] = p.Types
 	}
-	fmt.Printf("IMPORTING: %v from %v\n", bp.ImportPath, bp.Dir)
-	pkg := types.NewPackage(bp.Dir, bp.ImportPath)
-	pkg.SetImports(nil) //FIXME: do I need this?
-	pkg.MarkComplete()
-	return pkg, nil
-}
 
-func readFile(filename string) ([]byte, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	fx := &fixer.Fixer{
+		Log:   log,
+		Fixes: logrFixes,
+		Cache: &fixer.Cache{Dir: *cacheDir, Mode: parseCacheMode(log, *cacheMode)},
+		// Shares -cache/-cache-dir with the rewrite-result cache above, in
+		// its own subdirectory: the two caches key and serialize
+		// completely different things (rewritten source vs. gcexportdata
+		// type information) but there's no reason to make the user reason
+		// about two independent on/off/dir knobs for one "-cache" concept.
+		ExportCache: &importer.ExportCache{Dir: filepath.Join(*cacheDir, "export"), Mode: parseExportCacheMode(log, *cacheMode)},
+		// Lets retypechecking after a fix resolve the target import even
+		// when it wasn't part of the original package graph below.
+		ExtraImports: extraImports,
+		HandleFix:    handleFix,
 	}
-	defer f.Close()
 
-	src, err := ioutil.ReadAll(f)
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedImports | packages.NeedDeps,
+		// Load the test and xtest variants too, so klog calls in _test.go
+		// files get fixed along with the rest of the package.
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, flag.Args()...)
 	if err != nil {
-		return nil, err
+		log.Error(err, "failed to load packages", "patterns", flag.Args())
+		os.Exit(2)
 	}
-	return src, nil
-}
 
-func doPkg(pkg *Package) error {
-	for i, _ := range pkg.ASTFiles {
-		filename := pkg.Filenames[i]
-		ast := pkg.ASTFiles[i]
-		if err := doFile(filename, ast); err != nil {
-			return err
+	// Only fix the packages that matched a CLI pattern, not their
+	// transitive dependencies: packages.Load already typechecks the whole
+	// import graph up front so every dependency's type info is available
+	// without walking to it, and a dependency wasn't asked for -- it's very
+	// likely outside the user's module (vendored, read-only module-cache
+	// copy, or just not theirs to rewrite). Fixing one package also doesn't
+	// depend on another having been fixed first, so it's safe to fan the
+	// work for these roots out across a bounded worker pool instead of
+	// handling them one at a time.
+	//
+	// Tests: true above also hands back, for each matched import path, an
+	// internal "foo [foo.test]" variant that recompiles foo's non-test
+	// GoFiles together with its *_test.go files. Its GoFiles is a superset
+	// of the bare "foo" package's, so fixing both would write foo's shared,
+	// non-test files twice from two goroutines at once. Keep only the
+	// widest variant per import path; the external "foo_test [foo.test]"
+	// variant has its own distinct files and isn't affected.
+	widest := map[string]*packages.Package{}
+	for _, pkg := range pkgs {
+		if prev, ok := widest[pkg.PkgPath]; !ok || len(pkg.GoFiles) > len(prev.GoFiles) {
+			widest[pkg.PkgPath] = pkg
 		}
 	}
-	return nil
-}
-
-func doFile(filename string, ast *ast.File) error {
-	// Get the original source.
-	src, err := readFile(filename)
-	if err != nil {
-		return err
+	var all []*packages.Package
+	for _, pkg := range pkgs {
+		if widest[pkg.PkgPath] == pkg {
+			all = append(all, pkg)
+		}
 	}
 
-	// Apply fixes to this file.
-	fixed := false
-	for _, fix := range allFixes {
-		if fix.fn(filename, ast) {
-			fixed = true
-
-			// The AST changed, so we must re-parse it for the next fix to be
-			// additive.  We don't need to track the resultant ast.File beyond
-			// this function because the whole universe will be torn down in the
-			// outer loop calling this (for each top-level arg).
-			newSrc, err := gofmtFile(ast)
-			if err != nil {
-				return err
+	var (
+		mu     sync.Mutex
+		failed bool
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, runtime.GOMAXPROCS(0))
+	)
+	for _, pkg := range all {
+		pkg := pkg
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+			pkgFailed := false
+			for _, e := range pkg.Errors {
+				log.Error(e, "package load error", "pkg", pkg.PkgPath)
+				pkgFailed = true
 			}
-			ast, err = parser.ParseFile(fset, filename, newSrc, parser.ParseComments)
-			if err != nil {
-				return err
+			preFixErrs := len(pkg.Errors)
+			if err := fx.FixPackage(pkg); err != nil {
+				log.Error(err, "aborting package", "pkg", pkg.PkgPath)
+				pkgFailed = true
 			}
-		}
+			// A fix can append its own diagnostics to pkg.Errors while
+			// running (e.g. logrFix.findErrorArg flagging an ambiguous
+			// error argument it had to guess at) via fixer.AddErrorFrom.
+			// Drain those separately from the load errors above, since
+			// they only exist once FixPackage has run.
+			for _, e := range pkg.Errors[preFixErrs:] {
+				log.Error(e, "diagnostic while fixing package", "pkg", pkg.PkgPath)
+				pkgFailed = true
+			}
+			log.V(2).Info("fixed package", "pkg", pkg.PkgPath, "duration", time.Since(start))
+
+			if pkgFailed {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
 	}
-	if !fixed {
-		return nil
+	wg.Wait()
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func handleFix(info fixer.FileInfo) error {
+	src, err := ioutil.ReadFile(info.Name)
+	if err != nil {
+		return err
 	}
 
-	// Format the AST again.  We did this after each fix, so it appears
-	// redundant, but it is necessary to generate gofmt-compatible
-	// source code in a few cases. The official gofmt style is the
-	// output of the printer run on a standard AST generated by the parser,
-	// but the source we generated inside the loop above is the
-	// output of the printer run on a mangled AST generated by a fixer.
-	newSrc, err := gofmtFile(ast)
+	newSrc, err := fixer.GofmtFile(info.AST, info.Fset)
 	if err != nil {
 		return err
 	}
@@ -232,22 +288,12 @@ func doFile(filename string, ast *ast.File) error {
 		if err != nil {
 			return fmt.Errorf("computing diff: %s", err)
 		}
-		fmt.Printf("diff %s %s\n", filename, filepath.Join("fixed", filename))
+		fmt.Printf("diff %s %s\n", info.Name, info.Name)
 		os.Stdout.Write(data)
 		return nil
 	}
 
-	return ioutil.WriteFile(filename, newSrc, 0)
-}
-
-var gofmtBuf bytes.Buffer
-
-func gofmt(n interface{}) string {
-	gofmtBuf.Reset()
-	if err := format.Node(&gofmtBuf, fset, n); err != nil {
-		return "<" + err.Error() + ">"
-	}
-	return gofmtBuf.String()
+	return ioutil.WriteFile(info.Name, newSrc, 0)
 }
 
 func writeTempFile(dir, prefix string, data []byte) (string, error) {